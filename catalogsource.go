@@ -0,0 +1,261 @@
+package main
+
+// CatalogSource abstracts how a catalog's raw files are obtained so that
+// RancherCatalog no longer has to shell out to git/coreutils directly.
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func plumbingBranch(branch string) plumbing.ReferenceName {
+	return plumbing.NewBranchReferenceName(branch)
+}
+
+// CatalogSource fetches a catalog's contents into dest.
+type CatalogSource interface {
+	Fetch(dest string) error
+}
+
+// GitCatalogSource clones a single branch of a git repository.
+type GitCatalogSource struct {
+	Endpoint string
+	Branch   string
+}
+
+func (s *GitCatalogSource) Fetch(dest string) error {
+	_, err := git.PlainClone(dest, false, &git.CloneOptions{
+		URL:           s.Endpoint,
+		ReferenceName: plumbingBranch(s.Branch),
+		SingleBranch:  true,
+		Depth:         1,
+	})
+	return err
+}
+
+// LocalCatalogSource copies a catalog that already exists on disk, e.g.
+// file:///path/to/catalog or a bare filesystem path.
+type LocalCatalogSource struct {
+	Path string
+}
+
+func (s *LocalCatalogSource) Fetch(dest string) error {
+	return copyDir(s.Path, dest)
+}
+
+// TarballCatalogSource downloads and extracts a remote archive, such as
+// GitHub's codeload tarball/zip URLs.
+type TarballCatalogSource struct {
+	URL string
+}
+
+func (s *TarballCatalogSource) Fetch(dest string) error {
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: unexpected status %s", s.URL, resp.Status)
+	}
+
+	tmp, err := os.CreateTemp("", "catalog-archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err = io.Copy(tmp, resp.Body); err != nil {
+		return err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if u, err := url.Parse(s.URL); err == nil && (strings.HasSuffix(u.Path, ".zip") || strings.Contains(u.Path, "/zip/")) {
+		return extractZip(tmp.Name(), dest)
+	}
+	return extractTarGz(tmp, dest)
+}
+
+func extractTarGz(r io.Reader, dest string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	return stripFirstComponent(func(write func(name string, isDir bool, mode os.FileMode, content io.Reader) error) error {
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := write(hdr.Name, hdr.Typeflag == tar.TypeDir, os.FileMode(hdr.Mode), tr); err != nil {
+				return err
+			}
+		}
+	}, dest)
+}
+
+func extractZip(archivePath, dest string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	return stripFirstComponent(func(write func(name string, isDir bool, mode os.FileMode, content io.Reader) error) error {
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				if err := write(f.Name, true, f.Mode(), nil); err != nil {
+					return err
+				}
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = write(f.Name, false, f.Mode(), rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, dest)
+}
+
+// stripFirstComponent writes archive entries under dest after dropping the
+// leading path component, matching the layout of a plain git clone
+// (GitHub's codeload archives nest everything under "<repo>-<ref>/").
+func stripFirstComponent(walk func(write func(name string, isDir bool, mode os.FileMode, content io.Reader) error) error, dest string) error {
+	return walk(func(name string, isDir bool, mode os.FileMode, content io.Reader) error {
+		parts := strings.SplitN(filepath.ToSlash(name), "/", 2)
+		if len(parts) < 2 || parts[1] == "" {
+			return nil
+		}
+		target := filepath.Join(dest, filepath.FromSlash(parts[1]))
+		if isDir {
+			return os.MkdirAll(target, 0755)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		_, err = io.Copy(out, content)
+		return err
+	})
+}
+
+func copyDir(src, dest string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		return copyFile(path, target, info.Mode())
+	})
+}
+
+func copyFile(src, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// moveFile replaces exec.Command("mv", ...), falling back to a copy+remove
+// when src and dest straddle different devices (os.Rename returns EXDEV).
+func moveFile(src, dest string) error {
+	err := os.Rename(src, dest)
+	if !isCrossDevice(err) {
+		return err
+	}
+	if err := copyFile(src, dest, 0644); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// moveDir is moveFile's directory counterpart, used for version-directory
+// renames.
+func moveDir(src, dest string) error {
+	err := os.Rename(src, dest)
+	if !isCrossDevice(err) {
+		return err
+	}
+	if err := copyDir(src, dest); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// removeIfExists deletes path, treating a missing file as success.
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func isCrossDevice(err error) bool {
+	linkErr, ok := err.(*os.LinkError)
+	return ok && linkErr.Err == syscall.EXDEV
+}
+
+// newCatalogSource picks a CatalogSource implementation based on the
+// endpoint's URL scheme: plain filesystem paths and file:// URLs are read
+// locally, archive URLs are downloaded and extracted, and everything else
+// is treated as a git remote.
+func newCatalogSource(endpoint, branch string) CatalogSource {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" {
+		return &LocalCatalogSource{Path: endpoint}
+	}
+	if u.Scheme == "file" {
+		return &LocalCatalogSource{Path: u.Path}
+	}
+	if u.Host == "codeload.github.com" || strings.HasSuffix(u.Path, ".tar.gz") || strings.HasSuffix(u.Path, ".tgz") || strings.HasSuffix(u.Path, ".zip") {
+		return &TarballCatalogSource{URL: endpoint}
+	}
+	return &GitCatalogSource{Endpoint: endpoint, Branch: branch}
+}
@@ -0,0 +1,242 @@
+package main
+
+// Alternate --output-format=helm transform target: Rancher 2.x catalogs are
+// Helm charts with a questions.yml sidecar rather than the rancher-compose
+// docker-compose pairing this tool otherwise emits.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"gopkg.in/yaml.v2"
+
+	"github.com/LLParse/v2-catalog-transform/internal/compose"
+)
+
+const (
+	OutputFormatCompose = "compose"
+	OutputFormatHelm    = "helm"
+)
+
+// HelmChart is Chart.yaml's minimal shape.
+type HelmChart struct {
+	APIVersion  string `yaml:"apiVersion"`
+	Name        string `yaml:"name"`
+	Version     string `yaml:"version"`
+	Description string `yaml:"description,omitempty"`
+	Icon        string `yaml:"icon,omitempty"`
+}
+
+// HelmQuestion is a Rancher 2.x questions.yml entry: the v1 catalog
+// Question's variable, label, description, type, required, default and group
+// fields plus the show_if/show_subquestion_if conditionals. The v1 length,
+// range, options and valid/invalid character constraints are not carried over.
+type HelmQuestion struct {
+	Variable          string `yaml:"variable,omitempty"`
+	Label             string `yaml:"label,omitempty"`
+	Description       string `yaml:"description,omitempty"`
+	Type              string `yaml:"type,omitempty"`
+	Required          bool   `yaml:"required,omitempty"`
+	Default           string `yaml:"default,omitempty"`
+	Group             string `yaml:"group,omitempty"`
+	ShowIf            string `yaml:"show_if,omitempty"`
+	ShowSubquestionIf string `yaml:"show_subquestion_if,omitempty"`
+}
+
+func newHelmQuestion(q Question) HelmQuestion {
+	return HelmQuestion{
+		Variable:    q.Variable,
+		Label:       q.Label,
+		Description: q.Description,
+		Type:        q.Type,
+		Required:    q.Required,
+		Default:     q.Default,
+		Group:       q.Group,
+	}
+}
+
+// transformVersionHelm replaces RancherTemplateVersion.Transform's
+// docker-compose/rancher-compose output with a Helm chart version: a
+// Chart.yaml sidecar, questions.yml, a values.yaml skeleton, and
+// templates/ manifests derived from the compose services.
+func (t *RancherTemplate) transformVersionHelm(v *RancherTemplateVersion, opts *TransformOptions) error {
+	var catalog *RancherComposeCatalog
+	if v.RancherCompose != nil {
+		catalog = v.RancherCompose.Catalog
+	}
+	if catalog == nil {
+		catalog = &RancherComposeCatalog{}
+	}
+
+	if catalog.Version != "" {
+		p := strings.Split(v.Dir, "/")
+		p[len(p)-1] = catalog.Version
+		newDir := strings.Join(p, "/")
+		if err := moveDir(v.Dir, newDir); err != nil {
+			return err
+		}
+		v.Dir = newDir
+	}
+
+	chart := HelmChart{
+		APIVersion:  "v1",
+		Name:        t.Config.Name,
+		Version:     catalog.Version,
+		Description: t.Config.Description,
+	}
+	if t.IconFilepath != "" {
+		chart.Icon = t.IconFilepath
+	}
+	if err := writeYaml(strings.Join([]string{v.Dir, "Chart.yaml"}, "/"), chart); err != nil {
+		return err
+	}
+
+	questions := make([]HelmQuestion, 0, len(catalog.Questions))
+	values := map[string]string{}
+	for _, q := range catalog.Questions {
+		questions = append(questions, newHelmQuestion(q))
+		values[q.Variable] = q.Default
+	}
+	if err := writeYaml(strings.Join([]string{v.Dir, "questions.yml"}, "/"), questions); err != nil {
+		return err
+	}
+	if err := writeYaml(strings.Join([]string{v.Dir, "values.yaml"}, "/"), values); err != nil {
+		return err
+	}
+
+	if !opts.Preserve {
+		if err := removeIfExists(v.getRancherComposeFilepath(false)); err != nil {
+			return err
+		}
+	}
+
+	if len(v.dockerComposeData) == 0 {
+		return nil
+	}
+	files := []types.ConfigFile{{Filename: "docker-compose.yml", Content: v.dockerComposeData}}
+	if servicesData, err := stripCatalogKey(v.rancherComposeData); err != nil {
+		return err
+	} else if len(servicesData) > 0 {
+		files = append(files, types.ConfigFile{Filename: "rancher-compose.yml", Content: servicesData})
+	}
+	project, _, err := compose.LoadMerged(files, compose.Options{
+		WorkingDir: v.Dir,
+		Validate:   opts.Validate,
+	})
+	if err != nil {
+		if opts.Validate {
+			return err
+		}
+		v.Log.Warnf("compose validation: %s", err)
+		return nil
+	}
+
+	if err := writeKubeManifests(v.Dir, project); err != nil {
+		return err
+	}
+	if !opts.Preserve {
+		return removeIfExists(v.getDockerComposeFilepath(false))
+	}
+	return nil
+}
+
+// writeKubeManifests does a kompose-style conversion of each compose
+// service into a minimal Deployment under templates/, plus a Service for
+// each compose service that declares ports.
+func writeKubeManifests(dir string, project *types.Project) error {
+	templatesDir := strings.Join([]string{dir, "templates"}, "/")
+	if err := os.MkdirAll(templatesDir, 0755); err != nil {
+		return err
+	}
+	for _, svc := range project.Services {
+		deployment := kubeDeployment(svc)
+		if err := writeYaml(strings.Join([]string{templatesDir, svc.Name + "-deployment.yaml"}, "/"), deployment); err != nil {
+			return err
+		}
+		if len(svc.Ports) == 0 {
+			continue
+		}
+		service := kubeService(svc)
+		if err := writeYaml(strings.Join([]string{templatesDir, svc.Name + "-service.yaml"}, "/"), service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func kubeDeployment(svc types.ServiceConfig) map[string]interface{} {
+	env := make([]map[string]string, 0, len(svc.Environment))
+	for k, v := range svc.Environment {
+		val := ""
+		if v != nil {
+			val = *v
+		}
+		env = append(env, map[string]string{"name": k, "value": val})
+	}
+
+	ports := make([]map[string]interface{}, 0, len(svc.Ports))
+	for _, p := range svc.Ports {
+		ports = append(ports, map[string]interface{}{"containerPort": p.Target})
+	}
+
+	volumeMounts := make([]map[string]string, 0, len(svc.Volumes))
+	for i, vol := range svc.Volumes {
+		volumeMounts = append(volumeMounts, map[string]string{
+			"name":      fmt.Sprintf("vol-%d", i),
+			"mountPath": vol.Target,
+		})
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]string{"name": svc.Name},
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{"matchLabels": map[string]string{"app": svc.Name}},
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": map[string]string{"app": svc.Name}},
+				"spec": map[string]interface{}{
+					"containers": []map[string]interface{}{
+						{
+							"name":         svc.Name,
+							"image":        svc.Image,
+							"env":          env,
+							"ports":        ports,
+							"volumeMounts": volumeMounts,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func kubeService(svc types.ServiceConfig) map[string]interface{} {
+	ports := make([]map[string]interface{}, 0, len(svc.Ports))
+	for _, p := range svc.Ports {
+		ports = append(ports, map[string]interface{}{
+			"port":       p.Published,
+			"targetPort": p.Target,
+		})
+	}
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]string{"name": svc.Name},
+		"spec": map[string]interface{}{
+			"selector": map[string]string{"app": svc.Name},
+			"ports":    ports,
+		},
+	}
+}
+
+func writeYaml(path string, v interface{}) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransformVersionHelmNilCatalog(t *testing.T) {
+	dir := t.TempDir()
+	versionDir := filepath.Join(dir, "0")
+	if err := os.Mkdir(versionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &RancherTemplate{Config: &RancherTemplateConfig{Name: "example"}}
+	v := &RancherTemplateVersion{Dir: versionDir}
+	opts := &TransformOptions{Preserve: true}
+
+	if err := tmpl.transformVersionHelm(v, opts); err != nil {
+		t.Fatalf("transformVersionHelm: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(versionDir, "Chart.yaml")); err != nil {
+		t.Errorf("Chart.yaml not written: %v", err)
+	}
+}
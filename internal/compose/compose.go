@@ -0,0 +1,140 @@
+// Package compose loads and normalizes docker-compose files using the
+// compose-spec reference implementation instead of a bare yaml.Unmarshal,
+// so templates are validated and upgraded the same way `docker compose`
+// itself would interpret them.
+package compose
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/compose-spec/compose-go/v2/loader"
+	"github.com/compose-spec/compose-go/v2/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Options controls how a compose file is loaded and re-emitted.
+type Options struct {
+	// WorkingDir is passed through to the loader for relative path resolution.
+	WorkingDir string
+	// Environment supplies values for ${VAR} interpolation.
+	Environment map[string]string
+	// Validate fails Load if the document isn't compose-spec compliant.
+	Validate bool
+	// TargetVersion pins the schema version written back out, e.g. "3.7".
+	// Empty writes no top-level "version" key.
+	TargetVersion string
+}
+
+// Load parses a single docker-compose document, validating and
+// interpolating it through compose-go, and returns the project along with
+// its canonical re-serialization.
+func Load(filename string, data []byte, opts Options) (*types.Project, []byte, error) {
+	return LoadMerged([]types.ConfigFile{{Filename: filename, Content: data}}, opts)
+}
+
+// LoadMerged parses one or more compose documents and merges them in
+// order, later files overriding earlier ones, following the same rules
+// `docker compose -f a.yml -f b.yml` would apply. This replaces the
+// tool's hand-rolled service map merge.
+func LoadMerged(files []types.ConfigFile, opts Options) (*types.Project, []byte, error) {
+	normalized := make([]types.ConfigFile, len(files))
+	for i, f := range files {
+		data, err := normalizeV1(f.Content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("normalizing %s: %w", f.Filename, err)
+		}
+		normalized[i] = types.ConfigFile{Filename: f.Filename, Content: data}
+	}
+
+	details := types.ConfigDetails{
+		WorkingDir:  opts.WorkingDir,
+		ConfigFiles: normalized,
+		Environment: opts.Environment,
+	}
+
+	project, err := loader.LoadWithContext(context.Background(), details, func(o *loader.Options) {
+		o.SkipValidation = !opts.Validate
+		o.SkipNormalization = false
+		o.SkipConsistencyCheck = !opts.Validate
+		o.ResolvePaths = false
+		o.SetProjectName(projectName(opts.WorkingDir), false)
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading %s: %w", files[len(files)-1].Filename, err)
+	}
+
+	out, err := marshal(project, opts.TargetVersion)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshalling %s: %w", files[len(files)-1].Filename, err)
+	}
+	return project, out, nil
+}
+
+// projectName derives a compose-go project name from a template's working
+// directory, since the loader refuses to run without one and none of the
+// catalog templates declare a top-level "name" of their own. Falls back to
+// a fixed name for documents loaded without a working directory, e.g. in
+// tests.
+func projectName(workingDir string) string {
+	name := loader.NormalizeProjectName(filepath.Base(workingDir))
+	if name == "" {
+		return "template"
+	}
+	return name
+}
+
+// normalizeV1 upgrades a legacy v1 docker-compose/rancher-compose document
+// (a bare map of service name to service definition, with no top-level
+// "version" or "services" key) into the v2+ shape compose-go's loader
+// expects. Documents that already declare a "version" or "services" key,
+// along with empty ones, pass through unchanged.
+func normalizeV1(data []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return data, nil
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if _, ok := doc["services"]; ok {
+		return data, nil
+	}
+	if _, ok := doc["version"]; ok {
+		return data, nil
+	}
+	if len(doc) == 0 {
+		return data, nil
+	}
+
+	return yaml.Marshal(map[string]interface{}{"services": doc})
+}
+
+// marshal re-serializes a loaded project through compose-go's own types so
+// keys come out in the spec's canonical order rather than map iteration
+// order. compose-go v2 no longer emits a top-level "version" key itself
+// (the spec deprecated it), so when targetVersion is set we splice it back
+// in for compatibility with tooling that still expects one.
+func marshal(project *types.Project, targetVersion string) ([]byte, error) {
+	data, err := yaml.Marshal(project)
+	if err != nil {
+		return nil, err
+	}
+	if targetVersion == "" {
+		return data, nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	root := doc.Content[0]
+	root.Content = append([]*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: "version"},
+		{Kind: yaml.ScalarNode, Value: targetVersion},
+	}, root.Content...)
+	return yaml.Marshal(&doc)
+}
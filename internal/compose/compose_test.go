@@ -0,0 +1,62 @@
+package compose
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// v1DockerCompose is a legacy docker-compose.yml: a bare map of service
+// name to definition, with no "version" or "services" wrapper.
+const v1DockerCompose = `
+web:
+  image: nginx:latest
+  ports:
+    - "80:80"
+`
+
+// v1RancherCompose is a legacy rancher-compose.yml after its ".catalog"
+// key has been stripped, leaving only the per-service override.
+const v1RancherCompose = `
+web:
+  scale: 1
+`
+
+func TestLoadMergedNormalizesV1Compose(t *testing.T) {
+	files := []types.ConfigFile{
+		{Filename: "docker-compose.yml", Content: []byte(v1DockerCompose)},
+		{Filename: "rancher-compose.yml", Content: []byte(v1RancherCompose)},
+	}
+
+	project, out, err := LoadMerged(files, Options{WorkingDir: "."})
+	if err != nil {
+		t.Fatalf("LoadMerged: %v", err)
+	}
+
+	svc, err := project.GetService("web")
+	if err != nil {
+		t.Fatalf("service %q missing from merged project: %v", "web", err)
+	}
+	if svc.Image != "nginx:latest" {
+		t.Errorf("image = %q, want %q", svc.Image, "nginx:latest")
+	}
+	if !strings.Contains(string(out), "nginx:latest") {
+		t.Errorf("marshalled output missing image:\n%s", out)
+	}
+}
+
+func TestLoadMergedPassesThroughV2Compose(t *testing.T) {
+	const v2 = `
+services:
+  web:
+    image: nginx:latest
+`
+	project, _, err := Load("docker-compose.yml", []byte(v2), Options{WorkingDir: "."})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if _, err := project.GetService("web"); err != nil {
+		t.Fatalf("service %q missing: %v", "web", err)
+	}
+}
@@ -3,27 +3,59 @@ package main
 // Transform Rancher catalog into normalized v2 format.
 
 import (
-	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"runtime"
 	"strings"
+	"sync/atomic"
 
-	log "github.com/Sirupsen/logrus"
+	"github.com/compose-spec/compose-go/v2/types"
+	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
+
+	"github.com/LLParse/v2-catalog-transform/internal/compose"
 )
 
+// TransformOptions carries the CLI flags that affect how a catalog is
+// rewritten, threaded down through RancherCatalog -> RancherTemplate ->
+// RancherTemplateVersion.
+type TransformOptions struct {
+	// Preserve keeps the original files alongside the transformed ones.
+	Preserve bool
+	// Validate fails Transform if a template's compose file isn't spec-compliant.
+	Validate bool
+	// TargetVersion pins the compose schema version emitted, e.g. "3.7".
+	TargetVersion string
+	// RancherVersion filters/deprecates template versions outside their
+	// [minimum_rancher_version, maximum_rancher_version] window, e.g. "2.6.3".
+	RancherVersion string
+	// Strict fails Transform instead of warning on unparseable version
+	// strings or compatibility windows.
+	Strict bool
+	// OutputFormat selects the emitted catalog layout: OutputFormatCompose
+	// (rancher-compose + docker-compose, the default) or OutputFormatHelm.
+	OutputFormat string
+	// Report, when non-nil, accumulates a per-template summary that's
+	// written out as --report=json.
+	Report *Report
+	// Warnings collects per-template Warn-level log output so it can be
+	// attached to the report; nil when --report isn't set.
+	Warnings *warningCollector
+}
+
 type RancherCatalog struct {
 	Endpoint  string
 	Branch    string
 	CloneDir  string
+	Source    CatalogSource
 	Templates []*RancherTemplate
+	Jobs      int
 	Log       *log.Entry
 }
 
-func NewRancherCatalog(url string) *RancherCatalog {
+func NewRancherCatalog(url string, jobs int) *RancherCatalog {
 	p := strings.Split(url, "~")
 	endpoint := ""
 	branch := "master"
@@ -40,7 +72,9 @@ func NewRancherCatalog(url string) *RancherCatalog {
 	return &RancherCatalog{
 		Endpoint:  endpoint,
 		Branch:    branch,
+		Source:    newCatalogSource(endpoint, branch),
 		Templates: []*RancherTemplate{},
+		Jobs:      jobs,
 		Log: log.WithFields(log.Fields{
 			"endpoint": endpoint,
 			"branch":   branch,
@@ -51,12 +85,7 @@ func NewRancherCatalog(url string) *RancherCatalog {
 func (c *RancherCatalog) Clone() error {
 	parts := strings.Split(c.Endpoint, "/")
 	c.CloneDir = fmt.Sprintf("output/%s-%s", parts[len(parts)-1], c.Branch)
-	out, err := exec.Command("git", "clone", c.Endpoint, "--quiet",
-		"--single-branch", "--branch", c.Branch, c.CloneDir).CombinedOutput()
-	if err != nil {
-		err = errors.New(fmt.Sprintf("[%s] %s", err, string(out)))
-	}
-	return err
+	return c.Source.Fetch(c.CloneDir)
 }
 
 func (c *RancherCatalog) Parse() error {
@@ -75,21 +104,52 @@ func (c *RancherCatalog) Parse() error {
 			}
 		}
 	}
-	for _, t := range c.Templates {
-		if err := t.Parse(); err != nil {
-			return err
-		}
-	}
-	return nil
+	return runPool(c.Jobs, len(c.Templates), func(i int) error {
+		return c.Templates[i].Parse()
+	})
 }
 
-func (c *RancherCatalog) Transform(preserve *bool) error {
-	for _, t := range c.Templates {
-		if err := t.Transform(preserve); err != nil {
-			return err
+func (c *RancherCatalog) Transform(opts *TransformOptions) error {
+	var done int32
+	total := len(c.Templates)
+	err := runPool(c.Jobs, total, func(i int) error {
+		t := c.Templates[i]
+		t.Log.WithField("event", "template_started").Info("template_started")
+
+		err := t.Transform(opts)
+
+		status := "ok"
+		errMsg := ""
+		if err != nil {
+			status = "error"
+			errMsg = err.Error()
 		}
-	}
-	return nil
+		t.Log.WithFields(log.Fields{
+			"event":  "template_transformed",
+			"status": status,
+			"count":  fmt.Sprintf("%d/%d", atomic.AddInt32(&done, 1), total),
+		}).Info("template_transformed")
+
+		if opts.Report != nil {
+			versions := make([]string, len(t.Versions))
+			for vi, v := range t.Versions {
+				versions[vi] = v.Dir
+			}
+			var warnings []string
+			if opts.Warnings != nil {
+				warnings = opts.Warnings.DrainPrefix(t.Dir)
+			}
+			opts.Report.Add(ReportEntry{
+				Template: t.Dir,
+				Versions: versions,
+				Status:   status,
+				Error:    errMsg,
+				Warnings: warnings,
+			})
+		}
+		return err
+	})
+	return err
 }
 
 func (c *RancherCatalog) String() string {
@@ -152,9 +212,28 @@ func (t *RancherTemplate) Parse() error {
 	return nil
 }
 
-func (t *RancherTemplate) Transform(preserve *bool) error {
+func (t *RancherTemplate) Transform(opts *TransformOptions) error {
+	for _, v := range t.Versions {
+		if _, err := versionOf(v); err != nil {
+			if opts.Strict {
+				return fmt.Errorf("%s: %w", v.Dir, err)
+			}
+			t.Log.Warnf("unparseable version in %s: %s", v.Dir, err)
+		}
+	}
+	if err := t.filterVersions(opts); err != nil {
+		return err
+	}
+	sortVersionsDescending(t.Versions)
+
 	// adjust and move the config file
-	t.Config.DefaultVersion = t.Config.Version
+	if t.Config.Version != "" {
+		t.Config.DefaultVersion = t.Config.Version
+	} else if len(t.Versions) > 0 {
+		if dv, err := versionOf(t.Versions[0]); err == nil {
+			t.Config.DefaultVersion = dv.String()
+		}
+	}
 	t.Config.Version = ""
 	t.Config.ProjectURL = t.Config.OldProjectURL
 	t.Config.OldProjectURL = ""
@@ -167,7 +246,7 @@ func (t *RancherTemplate) Transform(preserve *bool) error {
 		if err = ioutil.WriteFile(newConfigFilepath, data, 0644); err != nil {
 			return err
 		}
-		if !*preserve {
+		if !opts.Preserve {
 			if err = os.Remove(t.ConfigFilepath); err != nil {
 				return err
 			}
@@ -181,29 +260,90 @@ func (t *RancherTemplate) Transform(preserve *bool) error {
 		q := strings.Split(p[len(p)-1], ".")
 		p[len(p)-1] = fmt.Sprintf("icon.%s", q[len(q)-1])
 		newIconFilepath := strings.Join(p, "/")
-		if err := exec.Command("mv", t.IconFilepath, newIconFilepath).Run(); err != nil {
+		if err := moveFile(t.IconFilepath, newIconFilepath); err != nil {
 			return err
 		}
 		t.IconFilepath = newIconFilepath
 	}
 
 	// config.yml -> template.yml
+	entries := make([]VersionsIndexEntry, 0, len(t.Versions))
 	for _, v := range t.Versions {
-		if err := v.Transform(preserve); err != nil {
+		var err error
+		if opts.OutputFormat == OutputFormatHelm {
+			err = t.transformVersionHelm(v, opts)
+		} else {
+			err = v.Transform(opts)
+		}
+		if err != nil {
 			return err
 		}
+		if v.RancherCompose != nil && v.RancherCompose.Catalog != nil {
+			c := v.RancherCompose.Catalog
+			entries = append(entries, VersionsIndexEntry{
+				Version:               c.Version,
+				MinimumRancherVersion: c.MinimumRancherVersion,
+				MaximumRancherVersion: c.MaximumRancherVersion,
+				Deprecated:            v.Deprecated,
+			})
+		}
+	}
+	if data, err := yaml.Marshal(entries); err != nil {
+		return err
+	} else if err := ioutil.WriteFile(strings.Join([]string{t.Dir, "versions.yml"}, "/"), data, 0644); err != nil {
+		return err
 	}
 	return nil
 }
 
+// filterVersions applies the --rancher-version compatibility window to
+// each version, marking it deprecated (or, under --strict, dropping it)
+// when it falls outside [minimum_rancher_version, maximum_rancher_version].
+func (t *RancherTemplate) filterVersions(opts *TransformOptions) error {
+	if opts.RancherVersion == "" {
+		return nil
+	}
+	kept := t.Versions[:0]
+	for _, v := range t.Versions {
+		if v.RancherCompose == nil || v.RancherCompose.Catalog == nil {
+			kept = append(kept, v)
+			continue
+		}
+		inRange, err := compatibilityRange(v.RancherCompose.Catalog, opts.RancherVersion)
+		if err != nil {
+			if opts.Strict {
+				return err
+			}
+			t.Log.Warnf("unparseable compatibility window: %s", err)
+			kept = append(kept, v)
+			continue
+		}
+		if !inRange {
+			if opts.Strict {
+				t.Log.Warnf("dropping version %s: incompatible with rancher %s", v.RancherCompose.Catalog.Version, opts.RancherVersion)
+				continue
+			}
+			v.Deprecated = true
+		}
+		kept = append(kept, v)
+	}
+	t.Versions = kept
+	return nil
+}
+
 type RancherTemplateVersion struct {
-	Dir              string
-	DockerComposeV1  *DockerComposeV1
-	DockerComposeV2  *DockerComposeV2
-	RancherComposeV1 *DockerComposeV1
-	RancherComposeV2 *DockerComposeV2
-	RancherCompose   *RancherCompose
-	Log              *log.Entry
+	Dir            string
+	RancherCompose *RancherCompose
+	// Deprecated marks a version outside the --rancher-version compatibility
+	// window; it's still emitted, just flagged in versions.yml.
+	Deprecated bool
+	Log        *log.Entry
+
+	// raw service definitions kept between Parse and Transform so the
+	// compose-go merge can apply the --validate/--target-version flags,
+	// which aren't known until Transform runs.
+	dockerComposeData  []byte
+	rancherComposeData []byte
 }
 
 func NewRancherTemplateVersion(t *RancherTemplate, versionDir string) *RancherTemplateVersion {
@@ -235,24 +375,6 @@ func (v *RancherTemplateVersion) getDockerComposeFilepath(newFilename bool) stri
 	return filepath
 }
 
-type VersionDetector struct {
-	Version string
-}
-
-func (v *RancherTemplateVersion) DetectComposeVersion(data []byte) string {
-	version := "1"
-
-	vd := VersionDetector{}
-	if err := yaml.Unmarshal(data, &vd); err == nil {
-		switch vd.Version {
-		case "2":
-			version = vd.Version
-		}
-	}
-
-	return version
-}
-
 func (v *RancherTemplateVersion) Parse() error {
 
 	if data, err := ioutil.ReadFile(v.getRancherComposeFilepath(false)); err != nil {
@@ -262,68 +384,25 @@ func (v *RancherTemplateVersion) Parse() error {
 		if err = yaml.Unmarshal(data, &rc); err == nil {
 			v.RancherCompose = &rc
 		}
-
-		switch v.DetectComposeVersion(data) {
-		case "1":
-			dc := DockerComposeV1{}
-			if err = yaml.Unmarshal(data, &dc); err == nil {
-				v.RancherComposeV1 = &dc
-			}
-		case "2":
-			dc := DockerComposeV2{}
-			if err = yaml.Unmarshal(data, &dc); err == nil {
-				v.RancherComposeV2 = &dc
-			}
-		}
+		v.rancherComposeData = data
 	}
 
 	if data, err := ioutil.ReadFile(v.getDockerComposeFilepath(false)); err != nil {
 		v.Log.Warn("Error reading docker-compose.yml")
 	} else {
-		switch v.DetectComposeVersion(data) {
-		case "1":
-			dc := DockerComposeV1{}
-			if err = yaml.Unmarshal(data, &dc); err == nil {
-				v.DockerComposeV1 = &dc
-			}
-		case "2":
-			dc := DockerComposeV2{}
-			if err = yaml.Unmarshal(data, &dc); err == nil {
-				v.DockerComposeV2 = &dc
-			}
-		}
+		v.dockerComposeData = data
 	}
 
 	return nil
 }
 
-type Service map[string]map[string]interface{}
-
-func (v *RancherTemplateVersion) merge(a Service, b Service) Service {
-	if a == nil {
-		return b
-	} else if b == nil {
-		return a
-	}
-	for ak, av := range a {
-		if b[ak] == nil {
-			b[ak] = av
-		} else {
-			for avk, avv := range av {
-				b[ak][avk] = avv
-			}
-		}
-	}
-	return b
-}
-
-func (v *RancherTemplateVersion) Transform(preserve *bool) error {
+func (v *RancherTemplateVersion) Transform(opts *TransformOptions) error {
 	// rename the root folder to catalog version
 	if v.RancherCompose.Catalog != nil && v.RancherCompose.Catalog.Version != "" {
 		p := strings.Split(v.Dir, "/")
 		p[len(p)-1] = v.RancherCompose.Catalog.Version
 		newDir := strings.Join(p, "/")
-		if err := exec.Command("mv", v.Dir, newDir).Run(); err != nil {
+		if err := moveDir(v.Dir, newDir); err != nil {
 			return err
 		}
 		v.Dir = newDir
@@ -335,47 +414,69 @@ func (v *RancherTemplateVersion) Transform(preserve *bool) error {
 	} else if err = ioutil.WriteFile(v.getRancherComposeFilepath(true), data, 0644); err != nil {
 		return err
 	}
-	if !*preserve {
+	if !opts.Preserve {
 		if err := os.Remove(v.getRancherComposeFilepath(false)); err != nil {
 			return err
 		}
 	}
 
-	// merge docker/rancher compose into data
-	var data []byte
-	var err error
-	// docker/rancher compose files may be either v1 or v2
-	switch {
-	case v.DockerComposeV1 != nil && v.RancherComposeV1 != nil:
-		v.DockerComposeV1.Services = v.merge(v.DockerComposeV1.Services, v.RancherComposeV1.Services)
-		data, err = yaml.Marshal(v.DockerComposeV1)
-	case v.DockerComposeV1 != nil && v.RancherComposeV2 != nil:
-		v.DockerComposeV1.Services = v.merge(v.DockerComposeV1.Services, v.RancherComposeV2.Services)
-		data, err = yaml.Marshal(v.DockerComposeV1)
-	case v.DockerComposeV2 != nil && v.RancherComposeV1 != nil:
-		v.DockerComposeV2.Services = v.merge(v.DockerComposeV2.Services, v.RancherComposeV1.Services)
-		data, err = yaml.Marshal(v.DockerComposeV2)
-	case v.DockerComposeV2 != nil && v.RancherComposeV2 != nil:
-		v.DockerComposeV2.Services = v.merge(v.DockerComposeV2.Services, v.RancherComposeV2.Services)
-		data, err = yaml.Marshal(v.DockerComposeV2)
-	}
-
-	if err != nil {
-		return err
-	} else if len(data) > 0 {
-		err = ioutil.WriteFile(v.getDockerComposeFilepath(true), data, 0644)
-		if err != nil {
+	// merge docker-compose.yml and rancher-compose.yml's service overrides
+	// through compose-go, which understands the compose-spec's own merge
+	// rules instead of our hand-rolled map merge. The ".catalog" key isn't
+	// part of the spec, so it's stripped before rancher-compose.yml is fed
+	// in as an override file.
+	if len(v.dockerComposeData) > 0 {
+		files := []types.ConfigFile{
+			{Filename: "docker-compose.yml", Content: v.dockerComposeData},
+		}
+		if servicesData, err := stripCatalogKey(v.rancherComposeData); err != nil {
 			return err
+		} else if len(servicesData) > 0 {
+			files = append(files, types.ConfigFile{Filename: "rancher-compose.yml", Content: servicesData})
 		}
-		if !*preserve {
-			if err := os.Remove(v.getDockerComposeFilepath(false)); err != nil {
+
+		_, data, err := compose.LoadMerged(files, compose.Options{
+			WorkingDir:    v.Dir,
+			Validate:      opts.Validate,
+			TargetVersion: opts.TargetVersion,
+		})
+		if err != nil {
+			if opts.Validate {
 				return err
 			}
+			v.Log.Warnf("compose validation: %s", err)
+		} else {
+			if err := ioutil.WriteFile(v.getDockerComposeFilepath(true), data, 0644); err != nil {
+				return err
+			}
+			if !opts.Preserve {
+				if err := os.Remove(v.getDockerComposeFilepath(false)); err != nil {
+					return err
+				}
+			}
 		}
 	}
 	return nil
 }
 
+// stripCatalogKey removes the Rancher-specific ".catalog" key from a
+// rancher-compose.yml document so the remaining service overrides can be
+// loaded as a plain compose file.
+func stripCatalogKey(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	delete(doc, ".catalog")
+	if len(doc) == 0 {
+		return nil, nil
+	}
+	return yaml.Marshal(doc)
+}
+
 type RancherCompose struct {
 	Catalog *RancherComposeCatalog `yaml:".catalog"`
 }
@@ -409,19 +510,6 @@ type Question struct {
 	InvalidChars string   `yaml:"invalid_chars,omitempty"`
 }
 
-type DockerComposeV1 struct {
-	// This field exists so we may parse a rancher-compose.yml file as a
-	// docker-compose.yml file without treating '.catalog' as an inline service
-	Catalog  *RancherComposeCatalog            `yaml:".catalog,omitempty"`
-	Services map[string]map[string]interface{} `yaml:"services,inline"`
-}
-
-type DockerComposeV2 struct {
-	Version  string                            `yaml:"version"`
-	Services map[string]map[string]interface{} `yaml:"services"`
-	Volumes  map[string]interface{}            `yaml:"volumes,omitempty"`
-}
-
 type RancherTemplateConfig struct {
 	Name           string            `yaml:"name"`
 	Version        string            `yaml:"version,omitempty"`
@@ -435,6 +523,13 @@ type RancherTemplateConfig struct {
 
 func main() {
 	preserve := flag.Bool("preserve", false, "Preserve original files for comparison & backwards compatibility")
+	validate := flag.Bool("validate", false, "Fail Transform if a template's compose file is not spec-compliant")
+	targetVersion := flag.String("target-version", "", "Pin the compose schema version emitted, e.g. 3.7")
+	rancherVersion := flag.String("rancher-version", "", "Filter/deprecate versions outside their minimum/maximum_rancher_version window, e.g. 2.6.3")
+	strict := flag.Bool("strict", false, "Fail instead of warn on unparseable version strings or compatibility windows")
+	outputFormat := flag.String("output-format", OutputFormatCompose, "Catalog layout to emit: compose or helm")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "Maximum number of templates/catalogs to process concurrently")
+	report := flag.String("report", "", "Write a transformation summary to output/report.<format>; only \"json\" is supported")
 	flag.Parse()
 	if urls := flag.Args(); len(urls) == 0 {
 		log.Fatalf(`Must provide at least one URL as argument
@@ -447,30 +542,60 @@ Example:
 			log.Infof("Preserve enabled")
 		}
 
-		catalogs := make(map[string]map[string]*RancherCatalog)
-		for _, url := range urls {
-			c := NewRancherCatalog(url)
-			if catalogs[c.Endpoint] == nil {
-				catalogs[c.Endpoint] = make(map[string]*RancherCatalog)
-			}
-			catalogs[c.Endpoint][c.Branch] = c
+		if *outputFormat != OutputFormatCompose && *outputFormat != OutputFormatHelm {
+			log.Fatalf("Invalid --output-format: %s", *outputFormat)
+		}
+		if *report != "" && *report != "json" {
+			log.Fatalf("Invalid --report format: %s", *report)
+		}
+
+		opts := &TransformOptions{
+			Preserve:       *preserve,
+			Validate:       *validate,
+			TargetVersion:  *targetVersion,
+			RancherVersion: *rancherVersion,
+			Strict:         *strict,
+			OutputFormat:   *outputFormat,
+		}
+		if *report != "" {
+			opts.Report = &Report{}
+			opts.Warnings = newWarningCollector()
+			log.AddHook(opts.Warnings)
+		}
+
+		catalogs := make([]*RancherCatalog, len(urls))
+		for i, url := range urls {
+			catalogs[i] = NewRancherCatalog(url, *jobs)
+		}
+
+		err := runPool(*jobs, len(catalogs), func(i int) error {
+			c := catalogs[i]
 			c.Log.Info("Begin")
 
-			var err error
-			if err = c.Clone(); err != nil {
-				c.Log.Fatalf("Error cloning catalog: %s", err)
+			if err := c.Clone(); err != nil {
+				return fmt.Errorf("cloning catalog %s: %w", c, err)
 			}
 			c.Log.Info("Clone Complete")
 
-			if err = c.Parse(); err != nil {
-				c.Log.Fatalf("Error parsing catalog: %s", err)
+			if err := c.Parse(); err != nil {
+				return fmt.Errorf("parsing catalog %s: %w", c, err)
 			}
 			c.Log.Info("Parse Complete")
 
-			if err = c.Transform(preserve); err != nil {
-				c.Log.Fatalf("Error transforming catalog: %s", err)
+			if err := c.Transform(opts); err != nil {
+				return fmt.Errorf("transforming catalog %s: %w", c, err)
 			}
 			c.Log.Info("Transform Complete")
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if opts.Report != nil {
+			if err := opts.Report.WriteFile("output/report.json"); err != nil {
+				log.Fatalf("Error writing report: %s", err)
+			}
 		}
 		log.Info("Exiting")
 	}
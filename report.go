@@ -0,0 +1,87 @@
+package main
+
+// Optional --report=json output: a summary of every template processed in
+// a run, for CI gating on transformation status and validation warnings.
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ReportEntry summarizes one template's transformation.
+type ReportEntry struct {
+	Template string   `json:"template"`
+	Versions []string `json:"versions"`
+	Status   string   `json:"status"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Report accumulates ReportEntry values across concurrently-processed
+// templates.
+type Report struct {
+	mu      sync.Mutex
+	Entries []ReportEntry `json:"templates"`
+}
+
+func (r *Report) Add(entry ReportEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Entries = append(r.Entries, entry)
+}
+
+func (r *Report) WriteFile(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// warningCollector is a logrus hook that buckets Warn-level log entries by
+// the "dir" field template/version loggers already attach, so they can be
+// matched back up to a report entry once a template finishes.
+type warningCollector struct {
+	mu       sync.Mutex
+	warnings map[string][]string
+}
+
+func newWarningCollector() *warningCollector {
+	return &warningCollector{warnings: map[string][]string{}}
+}
+
+func (w *warningCollector) Levels() []log.Level {
+	return []log.Level{log.WarnLevel}
+}
+
+func (w *warningCollector) Fire(entry *log.Entry) error {
+	dir, _ := entry.Data["dir"].(string)
+	if dir == "" {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.warnings[dir] = append(w.warnings[dir], entry.Message)
+	return nil
+}
+
+// DrainPrefix removes and returns every warning logged against a dir
+// starting with prefix (a template's own dir or one of its version dirs).
+func (w *warningCollector) DrainPrefix(prefix string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var out []string
+	for dir, warnings := range w.warnings {
+		if dir == prefix || strings.HasPrefix(dir, prefix+"/") {
+			out = append(out, warnings...)
+			delete(w.warnings, dir)
+		}
+	}
+	return out
+}
@@ -0,0 +1,88 @@
+package main
+
+// Semver-aware sorting and compatibility filtering for template versions.
+// Catalog version directories are keyed off RancherComposeCatalog.Version,
+// which upstream catalogs don't guarantee is sorted or even valid semver,
+// so when config.yml omits its (legacy, single-version) "version" field,
+// DefaultVersion is taken from the highest valid semver version instead.
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/blang/semver/v4"
+)
+
+// parseVersion parses a catalog version string as semver, tolerating a
+// leading "v" the way git tags commonly do.
+func parseVersion(s string) (semver.Version, error) {
+	if len(s) > 0 && s[0] == 'v' {
+		s = s[1:]
+	}
+	return semver.Parse(s)
+}
+
+// sortVersionsDescending sorts versions with valid semver highest-first,
+// leaving any unparseable versions (already logged by the caller) in their
+// original relative order at the end.
+func sortVersionsDescending(versions []*RancherTemplateVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		vi, erri := versionOf(versions[i])
+		vj, errj := versionOf(versions[j])
+		if erri != nil && errj != nil {
+			return false
+		}
+		if erri != nil {
+			return false
+		}
+		if errj != nil {
+			return true
+		}
+		return vi.GT(vj)
+	})
+}
+
+func versionOf(v *RancherTemplateVersion) (semver.Version, error) {
+	if v.RancherCompose == nil || v.RancherCompose.Catalog == nil {
+		return semver.Version{}, fmt.Errorf("no catalog metadata")
+	}
+	return parseVersion(v.RancherCompose.Catalog.Version)
+}
+
+// compatibilityRange reports whether rancherVersion falls within a
+// version's [minimum_rancher_version, maximum_rancher_version] window.
+// An empty bound on either side is treated as unconstrained.
+func compatibilityRange(c *RancherComposeCatalog, rancherVersion string) (bool, error) {
+	target, err := parseVersion(rancherVersion)
+	if err != nil {
+		return false, err
+	}
+	if c.MinimumRancherVersion != "" {
+		min, err := parseVersion(c.MinimumRancherVersion)
+		if err != nil {
+			return false, err
+		}
+		if target.LT(min) {
+			return false, nil
+		}
+	}
+	if c.MaximumRancherVersion != "" {
+		max, err := parseVersion(c.MaximumRancherVersion)
+		if err != nil {
+			return false, err
+		}
+		if target.GT(max) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// VersionsIndexEntry is one row of the versions.yml index emitted at a
+// template's root once its versions have been sorted and filtered.
+type VersionsIndexEntry struct {
+	Version               string `yaml:"version"`
+	MinimumRancherVersion string `yaml:"minimum_rancher_version,omitempty"`
+	MaximumRancherVersion string `yaml:"maximum_rancher_version,omitempty"`
+	Deprecated            bool   `yaml:"deprecated,omitempty"`
+}
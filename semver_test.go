@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func versionFixture(version string) *RancherTemplateVersion {
+	return &RancherTemplateVersion{
+		RancherCompose: &RancherCompose{Catalog: &RancherComposeCatalog{Version: version}},
+	}
+}
+
+func TestSortVersionsDescending(t *testing.T) {
+	versions := []*RancherTemplateVersion{
+		versionFixture("1.0.0"),
+		versionFixture("not-semver"),
+		versionFixture("2.0.0"),
+		versionFixture("1.5.0"),
+	}
+
+	sortVersionsDescending(versions)
+
+	got := make([]string, len(versions))
+	for i, v := range versions {
+		got[i] = v.RancherCompose.Catalog.Version
+	}
+	want := []string{"2.0.0", "1.5.0", "1.0.0", "not-semver"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sortVersionsDescending() = %v, want %v", got, want)
+		}
+	}
+}